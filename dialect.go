@@ -0,0 +1,267 @@
+package goway
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// lockName identifies goway's advisory lock to the database. It's the
+// same for every caller, since only one migration run is ever meant to
+// happen against a given database at a time.
+const lockName = "goway_migrations"
+
+// lockTimeoutSeconds bounds how long a losing instance waits for the
+// winner to finish applying migrations before giving up, on mysql and
+// sqlite3. The postgres lock doesn't use it and waits without a bound.
+const lockTimeoutSeconds = 60
+
+// postgresLockKey is a fixed int64 advisory lock key derived from
+// lockName, since pg_advisory_lock takes a number rather than a string.
+var postgresLockKey = int64(fnvHash(lockName))
+
+func fnvHash(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// Dialect abstracts the SQL differences between database engines so that
+// the rest of the package doesn't need to special-case any particular
+// driver. Each supported engine (mysql, postgres, sqlite3) provides its
+// own implementation.
+type Dialect interface {
+	// Name returns the dialect's identifier, e.g. "mysql".
+	Name() string
+
+	// CreateVersionTableSQL returns the statement that creates the
+	// db_versions table if it doesn't already exist.
+	CreateVersionTableSQL() string
+
+	// Placeholder returns the bind parameter placeholder for the given
+	// (1-based) position, e.g. "?" for mysql/sqlite3 or "$1" for postgres.
+	Placeholder(position int) string
+
+	// CurrentTimestamp returns the SQL expression used to stamp a
+	// migration with the current time, e.g. "NOW()".
+	CurrentTimestamp() string
+
+	// IsMissingTableError reports whether err is the driver-specific
+	// error returned when the db_versions table doesn't exist yet.
+	IsMissingTableError(err error) bool
+
+	// Lock acquires a cross-process advisory lock on db, blocking until
+	// it's available, so that multiple application instances booting at
+	// the same time don't race to apply the same migration. It returns a
+	// function that releases the lock. The mysql and sqlite3 dialects
+	// return an error instead once lockTimeoutSeconds have passed.
+	Lock(db *sql.DB) (unlock func() error, err error)
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string { return "mysql" }
+
+func (mysqlDialect) CreateVersionTableSQL() string {
+	return "CREATE TABLE IF NOT EXISTS db_versions (version INTEGER PRIMARY KEY NOT NULL, name VARCHAR(50) NOT NULL, applied DATETIME, checksum VARCHAR(64) NOT NULL DEFAULT '')"
+}
+
+func (mysqlDialect) Placeholder(position int) string { return "?" }
+
+func (mysqlDialect) CurrentTimestamp() string { return "NOW()" }
+
+func (mysqlDialect) IsMissingTableError(err error) bool {
+	// MySQL error 1146: "Table '...' doesn't exist".
+	return err != nil && strings.Contains(err.Error(), "1146")
+}
+
+func (mysqlDialect) Lock(db *sql.DB) (func() error, error) {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var acquired sql.NullInt64
+	if err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lockName, lockTimeoutSeconds).Scan(&acquired); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !acquired.Valid || acquired.Int64 != 1 {
+		conn.Close()
+		return nil, fmt.Errorf("goway: timed out waiting for migration lock %q", lockName)
+	}
+
+	return func() error {
+		defer conn.Close()
+		_, err := conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", lockName)
+		return err
+	}, nil
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string { return "postgres" }
+
+func (postgresDialect) CreateVersionTableSQL() string {
+	return "CREATE TABLE IF NOT EXISTS db_versions (version INTEGER PRIMARY KEY NOT NULL, name VARCHAR(50) NOT NULL, applied TIMESTAMP, checksum VARCHAR(64) NOT NULL DEFAULT '')"
+}
+
+func (postgresDialect) Placeholder(position int) string { return fmt.Sprintf("$%d", position) }
+
+func (postgresDialect) CurrentTimestamp() string { return "NOW()" }
+
+func (postgresDialect) IsMissingTableError(err error) bool {
+	// Postgres error 42P01: "relation ... does not exist".
+	return err != nil && strings.Contains(err.Error(), "42P01")
+}
+
+func (postgresDialect) Lock(db *sql.DB) (func() error, error) {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// pg_advisory_lock blocks until the lock is available, so losers of
+	// the race simply wait here instead of erroring out.
+	if _, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", postgresLockKey); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return func() error {
+		defer conn.Close()
+		_, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", postgresLockKey)
+		return err
+	}, nil
+}
+
+type sqlite3Dialect struct{}
+
+func (sqlite3Dialect) Name() string { return "sqlite3" }
+
+func (sqlite3Dialect) CreateVersionTableSQL() string {
+	return "CREATE TABLE IF NOT EXISTS db_versions (version INTEGER PRIMARY KEY NOT NULL, name VARCHAR(50) NOT NULL, applied DATETIME, checksum VARCHAR(64) NOT NULL DEFAULT '')"
+}
+
+func (sqlite3Dialect) Placeholder(position int) string { return "?" }
+
+func (sqlite3Dialect) CurrentTimestamp() string { return "CURRENT_TIMESTAMP" }
+
+func (sqlite3Dialect) IsMissingTableError(err error) bool {
+	// sqlite3 driver: "no such table: db_versions".
+	return err != nil && strings.Contains(err.Error(), "no such table")
+}
+
+func (sqlite3Dialect) Lock(db *sql.DB) (func() error, error) {
+	// SQLite has no session-level advisory lock. Holding a BEGIN IMMEDIATE
+	// transaction open for the whole upgrade would grab a write lock on
+	// the entire database file and self-deadlock as soon as any other
+	// pooled connection (e.g. getCurrentVersion's CREATE TABLE) tried to
+	// write. Use a sentinel row instead: each acquire/release is its own
+	// short, self-contained statement, so it never blocks the rest of the
+	// upgrade from using the connection pool normally.
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS goway_lock (id INTEGER PRIMARY KEY CHECK (id = 1), acquired_at DATETIME NOT NULL)"); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(lockTimeoutSeconds * time.Second)
+	for {
+		_, err := db.Exec("INSERT INTO goway_lock (id, acquired_at) VALUES (1, CURRENT_TIMESTAMP)")
+		if err == nil {
+			break
+		}
+		if !isSQLiteLockContentionError(err) {
+			return nil, err
+		}
+
+		// Unlike GET_LOCK/pg_advisory_lock, the sentinel row doesn't go
+		// away on its own if the process holding it dies (SIGKILL,
+		// OOM-kill, node loss) before running unlock(). Steal it once
+		// it's older than the timeout instead of waiting forever on a
+		// lock nobody is left to release.
+		db.Exec(fmt.Sprintf("DELETE FROM goway_lock WHERE id = 1 AND acquired_at < datetime('now', '-%d seconds')", lockTimeoutSeconds))
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("goway: timed out waiting for migration lock %q", lockName)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return func() error {
+		_, err := db.Exec("DELETE FROM goway_lock WHERE id = 1")
+		return err
+	}, nil
+}
+
+// isSQLiteLockContentionError reports whether err is one goway should
+// treat as "someone else holds the lock right now" and keep polling for,
+// rather than fail outright: either the sentinel row already exists
+// (UNIQUE constraint failed) or the database is transiently busy
+// (SQLITE_BUSY / "database is locked"), which can surface under real
+// contention depending on the caller's configured busy_timeout.
+func isSQLiteLockContentionError(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "UNIQUE constraint failed") ||
+		strings.Contains(msg, "database is locked") ||
+		strings.Contains(msg, "SQLITE_BUSY")
+}
+
+// DialectFor returns the Dialect registered under name ("mysql", "postgres"
+// or "sqlite3").
+func DialectFor(name string) (Dialect, error) {
+	switch name {
+	case "mysql":
+		return mysqlDialect{}, nil
+	case "postgres":
+		return postgresDialect{}, nil
+	case "sqlite3":
+		return sqlite3Dialect{}, nil
+	default:
+		return nil, fmt.Errorf("goway: unsupported dialect %q", name)
+	}
+}
+
+// dialectsByDriverName maps the name a driver is registered under via
+// sql.Register to the Dialect it corresponds to. Matching on the
+// registered name, rather than sniffing the driver struct's type (e.g.
+// "*stdlib.Driver" for github.com/jackc/pgx/v5/stdlib, which doesn't
+// otherwise look anything like "postgres"), keeps detection working for
+// any driver commonly registered under one of these names.
+var dialectsByDriverName = map[string]Dialect{
+	"sqlite3":  sqlite3Dialect{},
+	"sqlite":   sqlite3Dialect{},
+	"postgres": postgresDialect{},
+	"pgx":      postgresDialect{},
+	"mysql":    mysqlDialect{},
+}
+
+// detectDialect infers the Dialect to use from db's driver, so that
+// callers who don't care can just pass their *sql.DB straight to
+// Upgrade. Since a *sql.DB doesn't expose the name it was opened with,
+// this finds it indirectly: it opens a throwaway *sql.DB for each
+// candidate name and compares driver.Driver types until one matches.
+func detectDialect(db *sql.DB) (Dialect, error) {
+	driverType := reflect.TypeOf(db.Driver())
+	for _, name := range sql.Drivers() {
+		dialect, known := dialectsByDriverName[name]
+		if !known {
+			continue
+		}
+		probe, err := sql.Open(name, "")
+		if err != nil {
+			continue
+		}
+		matches := reflect.TypeOf(probe.Driver()) == driverType
+		probe.Close()
+		if matches {
+			return dialect, nil
+		}
+	}
+	return nil, fmt.Errorf("goway: could not detect dialect from driver %T, use DialectFor and UpgradeFSWithDialect instead", db.Driver())
+}
@@ -0,0 +1,27 @@
+package goway
+
+import (
+	"database/sql"
+	"testing"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// TestDetectDialectPgx guards against regressing detection for pgx's
+// stdlib driver, whose driver.Driver implementation (*stdlib.Driver)
+// doesn't contain "postgres" anywhere in its type name.
+func TestDetectDialectPgx(t *testing.T) {
+	db, err := sql.Open("pgx", "postgres://localhost/nonexistent")
+	if err != nil {
+		t.Fatalf("failed to open test database: %s", err)
+	}
+	defer db.Close()
+
+	dialect, err := detectDialect(db)
+	if err != nil {
+		t.Fatalf("detectDialect() returned an error: %s", err)
+	}
+	if dialect.Name() != "postgres" {
+		t.Fatalf("detectDialect() = %q, want \"postgres\"", dialect.Name())
+	}
+}
@@ -6,7 +6,8 @@
  * Properties:
  *  - no "down" migrations, only "up"
  *  - plain text files containing sql statements
- *  - located in ${GOPATH}/setup/migrations
+ *  - read from an fs.FS (e.g. an embed.FS, or os.DirFS for a plain directory
+ *    such as ${GOPATH}/setup/migrations)
  *  - filename format: "v([1-9][0-9]?)-([a-zA-Z]*)\.sql"
  * 
  * Migrations are applied in the increasing order of version in the filename. So
@@ -20,16 +21,15 @@
 package goway
 
 import (
-	"log"
 	"database/sql"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io/fs"
 	"os"
+	"path"
 	"regexp"
 	"sort"
 	"strconv"
-	"strings"
 	"time"
 )
 
@@ -41,10 +41,21 @@ type SchemaVersion struct {
 	Applied time.Time
 }
 
+// migrationKind distinguishes a plain .sql migration from one registered
+// in Go via Register.
+type migrationKind int
+
+const (
+	sqlMigration migrationKind = iota
+	goMigration
+)
+
 type Migration struct {
 	File        os.FileInfo
 	Number      int
 	Description string
+	Kind        migrationKind
+	GoFunc      func(*sql.Tx) error
 }
 
 type Migrations struct {
@@ -63,13 +74,13 @@ func (s Migrations) Swap(i, j int) {
 	s.m[i], s.m[j] = s.m[j], s.m[i]
 }
 
-func getCurrentVersion(db *sql.DB) (schema *SchemaVersion, err error) {
+func getCurrentVersion(db *sql.DB, dialect Dialect) (schema *SchemaVersion, err error) {
 	rows, err := db.Query("SELECT version, name, applied FROM db_versions ORDER BY version DESC LIMIT 1")
 
 	// oh, I wish there was a better way to check this...
 	if err != nil {
-		if strings.Contains(err.Error(), "1146") {
-			_, err = db.Exec("CREATE TABLE db_versions (version INTEGER PRIMARY KEY NOT NULL, name VARCHAR(50) NOT NULL, applied DATETIME)")
+		if dialect.IsMissingTableError(err) {
+			_, err = db.Exec(dialect.CreateVersionTableSQL())
 			if err != nil {
 				return nil, err
 			}
@@ -92,90 +103,157 @@ func getCurrentVersion(db *sql.DB) (schema *SchemaVersion, err error) {
 	return schema, nil
 }
 
-func getAvailableMigrations(location string) ([]Migration, error) {
-	files, err := ioutil.ReadDir(location)
+func getAvailableMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
 	if err != nil {
 		return nil, err
 	}
 
-	migrations := make([]Migration, len(files))
-	i := 0
-	for _, file := range files {
-		matches := regex.FindAllStringSubmatch(file.Name(), -1)
+	migrations := make([]Migration, 0, len(entries)+len(registeredGoMigrations))
+	for _, entry := range entries {
+		matches := regex.FindAllStringSubmatch(entry.Name(), -1)
 		if matches != nil && len(matches) == 1 {
+			info, err := entry.Info()
+			if err != nil {
+				return nil, err
+			}
 			version, _ := strconv.Atoi(matches[0][1])
-			migrations[i] = Migration{file, version, matches[0][2]}
-			i = i + 1
+			if _, exists := registeredGoMigrations[version]; exists {
+				panic(fmt.Sprintf("goway: duplicate migration version %d (both a .sql file and a Go migration)", version))
+			}
+			migrations = append(migrations, Migration{File: info, Number: version, Description: matches[0][2], Kind: sqlMigration})
 			//} else {
 			//	println(fmt.Sprintf("Ignoring migration file %s. Filename didn't match pattern!", files[idx].Name()))
 		}
 	}
-	sortable := Migrations{migrations[:i]}
+	for _, migration := range registeredGoMigrations {
+		migrations = append(migrations, migration)
+	}
+	sortable := Migrations{migrations}
 	sort.Sort(sortable)
 	return sortable.m, nil
 }
 
-func (migration Migration) apply(db *sql.DB, location string) error {
-	println(fmt.Sprintf("Applying migration %d - '%s'", migration.Number, migration.Description))
-
-	content, _ := ioutil.ReadFile(fmt.Sprintf("%s/%s", location, migration.File.Name()))
-	if len(content) == 0 {
-		return errors.New("Empty migrations file")
-	}
+func (migration Migration) apply(db *sql.DB, fsys fs.FS, dir string, dialect Dialect) error {
+	logger.Infof("Applying migration %d - '%s'", migration.Number, migration.Description)
 
 	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
-	statements := strings.Split(string(content), ";")
-	for _, statement := range statements {
-		statement = strings.TrimSpace(statement)
-		if len(statement) > 0 {
-			_, err = tx.Exec(statement)
-			if err != nil {
+
+	var sum string
+	switch migration.Kind {
+	case goMigration:
+		if err = migration.GoFunc(tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+		// There's no file content to hash, so checksum the version and
+		// name instead; this still catches a Go migration being renamed
+		// after the fact, or another migration taking over its version.
+		// A Go migration moved to a version with no db_versions row is
+		// not caught, since only versions that have a row are checked.
+		sum = checksum([]byte(fmt.Sprintf("%d-%s", migration.Number, migration.Description)))
+	default:
+		content, _ := fs.ReadFile(fsys, path.Join(dir, migration.File.Name()))
+		if len(content) == 0 {
+			tx.Rollback()
+			return errors.New("Empty migrations file")
+		}
+		for _, statement := range splitStatements(string(content)) {
+			if _, err = tx.Exec(statement); err != nil {
 				tx.Rollback()
 				return err
 			}
 		}
+		sum = checksum(content)
 	}
-	_, err = tx.Exec("INSERT INTO db_versions (version, name, applied) VALUES (?, ?, NOW())", migration.Number, migration.Description)
-	if err != nil {
+
+	insert := fmt.Sprintf("INSERT INTO db_versions (version, name, applied, checksum) VALUES (%s, %s, %s, %s)",
+		dialect.Placeholder(1), dialect.Placeholder(2), dialect.CurrentTimestamp(), dialect.Placeholder(3))
+	if _, err = tx.Exec(insert, migration.Number, migration.Description, sum); err != nil {
 		tx.Rollback()
 		return err
 	}
 	return tx.Commit()
 }
 
-func Upgrade(db *sql.DB, location string) {
-	println("Performing db schema upgrade...")
+// Upgrade applies the pending migrations found in the directory at
+// location on disk. It is kept for backwards compatibility; new callers
+// that want to embed their migrations in the binary should use UpgradeFS
+// with a //go:embed'd fs.FS instead.
+func Upgrade(db *sql.DB, location string) error {
+	return UpgradeFS(db, os.DirFS(location), ".")
+}
+
+// UpgradeFS applies the pending migrations found under dir in fsys, e.g.
+// an embed.FS populated with //go:embed migrations/*.sql. It returns an
+// error instead of exiting the process, so callers can decide how to
+// handle a failed upgrade.
+//
+// The dialect is auto-detected from db's driver. If db uses a driver
+// goway doesn't recognize, use UpgradeFSWithDialect instead and pass the
+// Dialect explicitly.
+func UpgradeFS(db *sql.DB, fsys fs.FS, dir string) error {
+	dialect, err := detectDialect(db)
+	if err != nil {
+		return fmt.Errorf("failed to detect the database dialect: %w", err)
+	}
+	return UpgradeFSWithDialect(db, fsys, dir, dialect)
+}
+
+// UpgradeFSWithDialect is UpgradeFS for callers whose driver goway can't
+// auto-detect; dialect is used as-is instead of being inferred from db.
+func UpgradeFSWithDialect(db *sql.DB, fsys fs.FS, dir string, dialect Dialect) error {
+	logger.Infof("Performing db schema upgrade...")
+
+	// Acquire a cross-process lock so that, when multiple instances boot
+	// at the same time (e.g. a Kubernetes rolling deployment), only one
+	// of them applies pending migrations. The rest block here and, once
+	// they get the lock, re-read the current version below and find
+	// nothing left to do.
+	unlock, err := dialect.Lock(db)
+	if err != nil {
+		return fmt.Errorf("failed to acquire the migration lock: %w", err)
+	}
+	defer unlock()
 
 	// Get a list of available migrations.
-	migrations, err := getAvailableMigrations(location)
+	migrations, err := getAvailableMigrations(fsys, dir)
 	if err != nil {
-		log.Fatalf("Failed to get a list of available database schema migrations: %s", err.Error())
+		return fmt.Errorf("failed to get a list of available database schema migrations: %w", err)
 	}
 
 	// Get the current version in the db.
-	current, err := getCurrentVersion(db)
+	current, err := getCurrentVersion(db, dialect)
 	if err != nil {
-		log.Fatalf("Failed to get the current database schema version: %s", err)
+		return fmt.Errorf("failed to get the current database schema version: %w", err)
+	}
+	logger.Infof("Current db version: %d, applied %s", current.Number, current.Applied)
+
+	// Make sure none of the already-applied migrations were edited after
+	// the fact, which would otherwise leave us with a silently drifted
+	// schema.
+	if err = verifyChecksums(db, fsys, dir, migrations); err != nil {
+		return err
 	}
-	println(fmt.Sprintf("Current db version: %d, applied %s", current.Number, current.Applied))
 
 	// Apply the pending migrations one by one in statements
 	for _, migration := range migrations {
 		if migration.Number > current.Number {
-			err = migration.apply(db, location)
+			err = migration.apply(db, fsys, dir, dialect)
 			if err != nil {
-				panic(fmt.Sprintf("Failed to update the database schema to version %d: %s", migration.Number, err))
+				return fmt.Errorf("failed to update the database schema to version %d: %w", migration.Number, err)
 			}
 		}
 	}
 
 	// Get the current version in the db.
-	current, err = getCurrentVersion(db)
+	current, err = getCurrentVersion(db, dialect)
 	if err != nil {
-		log.Fatalf("Failed to get the current database schema version: %s", err)
+		return fmt.Errorf("failed to get the current database schema version: %w", err)
 	}
-	println(fmt.Sprintf("Updated db version: %d, applied %s", current.Number, current.Applied))
+	logger.Infof("Updated db version: %d, applied %s", current.Number, current.Applied)
+	return nil
 }
@@ -0,0 +1,96 @@
+package goway
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	_ "modernc.org/sqlite"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open test database: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// TestUpgradeFSSQLite applies a real migration against a real sqlite3
+// database through the connection pool, the way an application would.
+// It guards against the lock implementation holding a transaction open
+// on a borrowed connection while the rest of the upgrade uses others,
+// which previously self-deadlocked with SQLITE_BUSY.
+func TestUpgradeFSSQLite(t *testing.T) {
+	db := openTestDB(t)
+	fsys := fstest.MapFS{
+		"v1-InitialSchema.sql": &fstest.MapFile{Data: []byte("CREATE TABLE foo (id INTEGER NOT NULL);")},
+	}
+
+	if err := UpgradeFS(db, fsys, "."); err != nil {
+		t.Fatalf("UpgradeFS() returned an error: %s", err)
+	}
+
+	version, err := Version(db)
+	if err != nil {
+		t.Fatalf("Version() returned an error: %s", err)
+	}
+	if version != 1 {
+		t.Fatalf("Version() = %d, want 1", version)
+	}
+
+	if _, err := db.Exec("INSERT INTO foo (id) VALUES (1)"); err != nil {
+		t.Fatalf("migration wasn't actually applied: %s", err)
+	}
+}
+
+func TestUpgradeFSChecksumMismatch(t *testing.T) {
+	db := openTestDB(t)
+	fsys := fstest.MapFS{
+		"v1-InitialSchema.sql": &fstest.MapFile{Data: []byte("CREATE TABLE foo (id INTEGER NOT NULL);")},
+	}
+	if err := UpgradeFS(db, fsys, "."); err != nil {
+		t.Fatalf("UpgradeFS() returned an error: %s", err)
+	}
+
+	// Edit the already-applied migration file behind goway's back.
+	fsys["v1-InitialSchema.sql"].Data = []byte("CREATE TABLE foo (id INTEGER NOT NULL, name TEXT);")
+
+	if err := UpgradeFS(db, fsys, "."); err == nil {
+		t.Fatal("UpgradeFS() should have failed on a modified already-applied migration")
+	}
+}
+
+func TestRegisterGoMigration(t *testing.T) {
+	db := openTestDB(t)
+
+	applied := false
+	Register(1, "SeedData", func(tx *sql.Tx) error {
+		applied = true
+		_, err := tx.Exec("CREATE TABLE foo (id INTEGER NOT NULL)")
+		return err
+	})
+	defer delete(registeredGoMigrations, 1)
+
+	if err := UpgradeFS(db, fstest.MapFS{}, "."); err != nil {
+		t.Fatalf("UpgradeFS() returned an error: %s", err)
+	}
+	if !applied {
+		t.Fatal("the registered Go migration was never run")
+	}
+}
+
+func TestRegisterDuplicateVersionPanics(t *testing.T) {
+	defer delete(registeredGoMigrations, 42)
+	Register(42, "First", func(tx *sql.Tx) error { return nil })
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Register() should have panicked on a duplicate version")
+		}
+	}()
+	Register(42, "Second", func(tx *sql.Tx) error { return nil })
+}
@@ -0,0 +1,29 @@
+package goway
+
+import "log"
+
+// Logger is the logging interface used by this package. Implement it to
+// route goway's output into your application's own logging stack; use
+// SetLogger to install it.
+type Logger interface {
+	Infof(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+	Fatalf(format string, args ...interface{})
+}
+
+// logger is the package-level Logger used by Upgrade/UpgradeFS. It defaults
+// to stdLogger, which writes to the standard library's log package.
+var logger Logger = stdLogger{}
+
+// SetLogger replaces the package-level logger.
+func SetLogger(l Logger) {
+	logger = l
+}
+
+type stdLogger struct{}
+
+func (stdLogger) Infof(format string, args ...interface{}) { log.Printf(format, args...) }
+
+func (stdLogger) Errorf(format string, args ...interface{}) { log.Printf(format, args...) }
+
+func (stdLogger) Fatalf(format string, args ...interface{}) { log.Fatalf(format, args...) }
@@ -0,0 +1,32 @@
+package goway
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// registeredGoMigrations holds every migration registered via Register,
+// keyed by version. It is merged with the .sql files discovered by
+// getAvailableMigrations into a single sorted migration sequence.
+var registeredGoMigrations = make(map[int]Migration)
+
+// Register records a migration implemented in Go rather than plain SQL,
+// for cases a .sql file can't express, e.g. a data backfill that needs
+// application-level parsing. up runs inside the same transaction as the
+// db_versions bookkeeping insert, so returning an error rolls back any
+// work it already did.
+//
+// Register panics if version has already been registered, the same way
+// Upgrade/UpgradeFS panic on a version collision between a Go migration
+// and a .sql file.
+func Register(version int, name string, up func(*sql.Tx) error) {
+	if _, exists := registeredGoMigrations[version]; exists {
+		panic(fmt.Sprintf("goway: duplicate migration version %d", version))
+	}
+	registeredGoMigrations[version] = Migration{
+		Number:      version,
+		Description: name,
+		Kind:        goMigration,
+		GoFunc:      up,
+	}
+}
@@ -0,0 +1,176 @@
+package goway
+
+import (
+	"strings"
+	"unicode"
+)
+
+// splitStatements splits the contents of a migration file into individual
+// SQL statements. Unlike a naive strings.Split(content, ";"), it
+// understands single- and double-quoted strings, "--" and "/* */"
+// comments, and Postgres-style dollar-quoted strings ($tag$...$tag$), so
+// semicolons inside any of those don't cause a false split.
+//
+// A block can also be marked explicitly as a single statement with a
+//
+//	-- +migration StatementBegin
+//	...
+//	-- +migration StatementEnd
+//
+// annotation pair, regardless of how many semicolons it contains - useful
+// for stored procedures, triggers, and other multi-statement bodies. The
+// annotation lines themselves are stripped from the resulting statement.
+func splitStatements(content string) []string {
+	forceStart, forceEnd := annotatedLines(content)
+
+	var statements []string
+	var current strings.Builder
+	flush := func() {
+		if s := strings.TrimSpace(current.String()); len(s) > 0 {
+			statements = append(statements, s)
+		}
+		current.Reset()
+	}
+
+	type scanState int
+	const (
+		normal scanState = iota
+		singleQuote
+		doubleQuote
+		lineComment
+		blockComment
+		dollarQuote
+	)
+
+	st := normal
+	forced := false
+	dollarTag := ""
+	line := 0
+
+	runes := []rune(content)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+
+		if forceStart[line] || forceEnd[line] {
+			// An annotation line is metadata, not SQL - scan past it
+			// without copying any of it into the statement being built.
+			if c == '\n' {
+				if forceStart[line] {
+					forced = true
+				}
+				if forceEnd[line] {
+					forced = false
+					flush()
+				}
+				line++
+			}
+			continue
+		}
+
+		if c == '\n' {
+			line++
+			if st == lineComment {
+				st = normal
+			}
+			current.WriteRune(c)
+			continue
+		}
+
+		switch st {
+		case lineComment, blockComment:
+			current.WriteRune(c)
+			if st == blockComment && c == '/' && i > 0 && runes[i-1] == '*' {
+				st = normal
+			}
+			continue
+		case singleQuote:
+			current.WriteRune(c)
+			if c == '\'' {
+				st = normal
+			}
+			continue
+		case doubleQuote:
+			current.WriteRune(c)
+			if c == '"' {
+				st = normal
+			}
+			continue
+		case dollarQuote:
+			current.WriteRune(c)
+			if c == '$' {
+				if tag, ok := matchDollarTag(runes, i); ok && tag == dollarTag {
+					current.WriteString(tag[1:])
+					i += len(tag) - 1
+					st = normal
+				}
+			}
+			continue
+		}
+
+		// st == normal
+		switch {
+		case c == '\'':
+			st = singleQuote
+			current.WriteRune(c)
+		case c == '"':
+			st = doubleQuote
+			current.WriteRune(c)
+		case c == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			st = lineComment
+			current.WriteRune(c)
+		case c == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			st = blockComment
+			current.WriteRune(c)
+		case c == '$':
+			if tag, ok := matchDollarTag(runes, i); ok {
+				dollarTag = tag
+				st = dollarQuote
+				current.WriteString(tag)
+				i += len(tag) - 1
+			} else {
+				current.WriteRune(c)
+			}
+		case c == ';' && !forced:
+			flush()
+		default:
+			current.WriteRune(c)
+		}
+	}
+	flush()
+	return statements
+}
+
+// annotatedLines scans content line by line (independent of the quote and
+// comment state tracked by splitStatements, just like goose's own
+// StatementBegin/End handling) and returns, by 0-based line number, which
+// lines open and close a forced single-statement block.
+func annotatedLines(content string) (start, end map[int]bool) {
+	start = make(map[int]bool)
+	end = make(map[int]bool)
+	for i, line := range strings.Split(content, "\n") {
+		switch strings.TrimSpace(line) {
+		case "-- +migration StatementBegin":
+			start[i] = true
+		case "-- +migration StatementEnd":
+			end[i] = true
+		}
+	}
+	return start, end
+}
+
+// matchDollarTag reports whether a Postgres dollar-quote tag ($$ or
+// $tag$) starts at runes[start], returning the full tag including both
+// dollar signs.
+func matchDollarTag(runes []rune, start int) (string, bool) {
+	if runes[start] != '$' {
+		return "", false
+	}
+	j := start + 1
+	for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_') {
+		j++
+	}
+	if j < len(runes) && runes[j] == '$' {
+		return string(runes[start : j+1]), true
+	}
+	return "", false
+}
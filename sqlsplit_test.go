@@ -0,0 +1,108 @@
+package goway
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatementsSimple(t *testing.T) {
+	content := "CREATE TABLE foo (id INT);\nCREATE TABLE bar (id INT)"
+	got := splitStatements(content)
+	want := []string{"CREATE TABLE foo (id INT)", "CREATE TABLE bar (id INT)"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("splitStatements() = %#v, want %#v", got, want)
+	}
+}
+
+func TestSplitStatementsSemicolonInStringLiteral(t *testing.T) {
+	content := `INSERT INTO foo (note) VALUES ('a; b');
+INSERT INTO foo (note) VALUES ('c');`
+	got := splitStatements(content)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 statements, got %d: %#v", len(got), got)
+	}
+	if got[0] != `INSERT INTO foo (note) VALUES ('a; b')` {
+		t.Fatalf("unexpected first statement: %q", got[0])
+	}
+}
+
+func TestSplitStatementsSemicolonInDoubleQuotedIdentifier(t *testing.T) {
+	content := `CREATE TABLE "weird;name" (id INT);
+SELECT 1;`
+	got := splitStatements(content)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 statements, got %d: %#v", len(got), got)
+	}
+}
+
+func TestSplitStatementsSemicolonInLineComment(t *testing.T) {
+	content := "-- this comment; has a semicolon\nSELECT 1;"
+	got := splitStatements(content)
+	if len(got) != 1 {
+		t.Fatalf("expected 1 statement, got %d: %#v", len(got), got)
+	}
+}
+
+func TestSplitStatementsSemicolonInBlockComment(t *testing.T) {
+	content := "/* a block; comment with a semicolon */\nSELECT 1;\nSELECT 2;"
+	got := splitStatements(content)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 statements, got %d: %#v", len(got), got)
+	}
+}
+
+func TestSplitStatementsDollarQuotedFunctionBody(t *testing.T) {
+	content := `CREATE FUNCTION foo() RETURNS int AS $$
+BEGIN
+    RETURN 1;
+END;
+$$ LANGUAGE plpgsql;
+SELECT 1;`
+	got := splitStatements(content)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 statements, got %d: %#v", len(got), got)
+	}
+}
+
+func TestSplitStatementsTaggedDollarQuote(t *testing.T) {
+	content := `CREATE FUNCTION foo() RETURNS int AS $body$
+BEGIN
+    RETURN 1;
+END;
+$body$ LANGUAGE plpgsql;`
+	got := splitStatements(content)
+	if len(got) != 1 {
+		t.Fatalf("expected 1 statement, got %d: %#v", len(got), got)
+	}
+}
+
+func TestSplitStatementsExplicitStatementBlock(t *testing.T) {
+	content := `-- +migration StatementBegin
+CREATE TRIGGER foo BEFORE INSERT ON bar
+BEGIN
+    SELECT 1;
+    SELECT 2;
+END;
+-- +migration StatementEnd
+SELECT 3;`
+	got := splitStatements(content)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 statements, got %d: %#v", len(got), got)
+	}
+	want := "CREATE TRIGGER foo BEFORE INSERT ON bar\nBEGIN\n    SELECT 1;\n    SELECT 2;\nEND;"
+	if got[0] != want {
+		t.Fatalf("unexpected first statement: %q, want %q", got[0], want)
+	}
+	if got[1] != "SELECT 3" {
+		t.Fatalf("unexpected second statement: %q", got[1])
+	}
+}
+
+func TestSplitStatementsIgnoresBlankStatements(t *testing.T) {
+	content := ";;\nSELECT 1;\n\n"
+	got := splitStatements(content)
+	want := []string{"SELECT 1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("splitStatements() = %#v, want %#v", got, want)
+	}
+}
@@ -0,0 +1,193 @@
+package goway
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"time"
+)
+
+// MigrationStatus describes a single known migration and whether it has
+// been applied to the database yet.
+type MigrationStatus struct {
+	Number  int
+	Name    string
+	Pending bool
+	Applied time.Time
+}
+
+// appliedMigration is a row of the db_versions table.
+type appliedMigration struct {
+	Name     string
+	Applied  time.Time
+	Checksum string
+}
+
+func checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// getAppliedMigrations returns every migration recorded in db_versions,
+// keyed by version. It assumes the table already exists.
+func getAppliedMigrations(db *sql.DB) (map[int]appliedMigration, error) {
+	const query = "SELECT version, name, applied, checksum FROM db_versions"
+	rows, err := db.Query(query)
+	if err != nil {
+		// A db_versions table created before the checksum column existed
+		// doesn't have it yet: add it (existing rows get an empty
+		// checksum) and try again.
+		if _, alterErr := db.Exec("ALTER TABLE db_versions ADD COLUMN checksum VARCHAR(64) NOT NULL DEFAULT ''"); alterErr != nil {
+			return nil, err
+		}
+		if rows, err = db.Query(query); err != nil {
+			return nil, err
+		}
+	}
+	defer rows.Close()
+
+	applied := make(map[int]appliedMigration)
+	for rows.Next() {
+		var version int
+		var record appliedMigration
+		if err = rows.Scan(&version, &record.Name, &record.Applied, &record.Checksum); err != nil {
+			return nil, err
+		}
+		applied[version] = record
+	}
+	return applied, rows.Err()
+}
+
+// verifyChecksums makes sure that none of the migration files already
+// recorded as applied have been edited since. Rows written before the
+// checksum column existed have an empty checksum and are skipped.
+func verifyChecksums(db *sql.DB, fsys fs.FS, dir string, migrations []Migration) error {
+	applied, err := getAppliedMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		record, ok := applied[migration.Number]
+		if !ok || record.Checksum == "" {
+			continue
+		}
+
+		var sum string
+		if migration.Kind == goMigration {
+			sum = checksum([]byte(fmt.Sprintf("%d-%s", migration.Number, migration.Description)))
+		} else {
+			content, err := fs.ReadFile(fsys, path.Join(dir, migration.File.Name()))
+			if err != nil {
+				return err
+			}
+			sum = checksum(content)
+		}
+		if sum != record.Checksum {
+			return fmt.Errorf("migration %d - '%s' has changed since it was applied (checksum mismatch)", migration.Number, migration.Description)
+		}
+	}
+	return nil
+}
+
+// Status reports, for every migration found under dir in fsys, whether it
+// is still pending or already applied (and when).
+//
+// The dialect is auto-detected from db's driver. If db uses a driver
+// goway doesn't recognize, use StatusWithDialect instead and pass the
+// Dialect explicitly.
+func Status(db *sql.DB, fsys fs.FS, dir string) ([]MigrationStatus, error) {
+	dialect, err := detectDialect(db)
+	if err != nil {
+		return nil, err
+	}
+	return StatusWithDialect(db, fsys, dir, dialect)
+}
+
+// StatusWithDialect is Status for callers whose driver goway can't
+// auto-detect; dialect is used as-is instead of being inferred from db.
+func StatusWithDialect(db *sql.DB, fsys fs.FS, dir string, dialect Dialect) ([]MigrationStatus, error) {
+	// getCurrentVersion also creates the db_versions table on first run.
+	if _, err := getCurrentVersion(db, dialect); err != nil {
+		return nil, err
+	}
+
+	migrations, err := getAvailableMigrations(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := getAppliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, migration := range migrations {
+		record, ok := applied[migration.Number]
+		statuses[i] = MigrationStatus{
+			Number:  migration.Number,
+			Name:    migration.Description,
+			Pending: !ok,
+			Applied: record.Applied,
+		}
+	}
+	return statuses, nil
+}
+
+// Version returns the schema version currently applied to db.
+//
+// The dialect is auto-detected from db's driver. If db uses a driver
+// goway doesn't recognize, use VersionWithDialect instead and pass the
+// Dialect explicitly.
+func Version(db *sql.DB) (int, error) {
+	dialect, err := detectDialect(db)
+	if err != nil {
+		return 0, err
+	}
+	return VersionWithDialect(db, dialect)
+}
+
+// VersionWithDialect is Version for callers whose driver goway can't
+// auto-detect; dialect is used as-is instead of being inferred from db.
+func VersionWithDialect(db *sql.DB, dialect Dialect) (int, error) {
+	current, err := getCurrentVersion(db, dialect)
+	if err != nil {
+		return 0, err
+	}
+	return current.Number, nil
+}
+
+// HasPending reports whether any migration found under dir in fsys has not
+// been applied to db yet, so that callers can decide whether to log
+// "upgrading schema" before running Upgrade/UpgradeFS.
+//
+// The dialect is auto-detected from db's driver. If db uses a driver
+// goway doesn't recognize, use HasPendingWithDialect instead and pass the
+// Dialect explicitly.
+func HasPending(db *sql.DB, fsys fs.FS, dir string) (bool, error) {
+	dialect, err := detectDialect(db)
+	if err != nil {
+		return false, err
+	}
+	return HasPendingWithDialect(db, fsys, dir, dialect)
+}
+
+// HasPendingWithDialect is HasPending for callers whose driver goway
+// can't auto-detect; dialect is used as-is instead of being inferred
+// from db.
+func HasPendingWithDialect(db *sql.DB, fsys fs.FS, dir string, dialect Dialect) (bool, error) {
+	statuses, err := StatusWithDialect(db, fsys, dir, dialect)
+	if err != nil {
+		return false, err
+	}
+	for _, status := range statuses {
+		if status.Pending {
+			return true, nil
+		}
+	}
+	return false, nil
+}